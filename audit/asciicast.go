@@ -0,0 +1,94 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// asciicastHeader is the first line of an asciicast v2 file.
+// See https://github.com/asciinema/asciinema/blob/develop/doc/asciicast-v2.md
+type asciicastHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Env       map[string]string `json:"env,omitempty"`
+	Title     string            `json:"title,omitempty"`
+}
+
+// Recorder writes a session's terminal I/O to w as an asciicast v2
+// recording: a header line followed by one JSON array per event. It is
+// safe for concurrent use by the session's browser and backend goroutines.
+type Recorder struct {
+	mu    sync.Mutex
+	w     io.Writer
+	start time.Time
+}
+
+// NewRecorder writes an asciicast v2 header describing a width x height
+// session called title to w, and returns a Recorder for its subsequent
+// output ("o"), input ("i") and resize ("r") events. Event timestamps are
+// monotonic, relative to the moment NewRecorder is called.
+func NewRecorder(w io.Writer, width, height int, title string, env map[string]string) (*Recorder, error) {
+	header := asciicastHeader{
+		Version:   2,
+		Width:     width,
+		Height:    height,
+		Timestamp: time.Now().Unix(),
+		Env:       env,
+		Title:     title,
+	}
+
+	encoded, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(append(encoded, '\n')); err != nil {
+		return nil, err
+	}
+
+	return &Recorder{w: w, start: time.Now()}, nil
+}
+
+// Output records a chunk of slave (backend) output.
+func (r *Recorder) Output(data []byte) error {
+	return r.writeEvent("o", string(data))
+}
+
+// Input records a chunk of master (browser) input.
+func (r *Recorder) Input(data []byte) error {
+	return r.writeEvent("i", string(data))
+}
+
+// Resize records a terminal resize to columns x rows.
+func (r *Recorder) Resize(columns, rows int) error {
+	return r.writeEvent("r", fmt.Sprintf("%dx%d", columns, rows))
+}
+
+func (r *Recorder) writeEvent(code, data string) error {
+	// Taking the timestamp under the lock keeps events written by
+	// concurrent goroutines in non-decreasing time order.
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	elapsed := time.Since(r.start).Seconds()
+
+	encoded, err := json.Marshal([]interface{}{elapsed, code, data})
+	if err != nil {
+		return err
+	}
+
+	_, err = r.w.Write(append(encoded, '\n'))
+	return err
+}
+
+// Close closes the underlying writer if it implements io.Closer.
+func (r *Recorder) Close() error {
+	if closer, ok := r.w.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+// Package audit provides a structured event sink for GoTTY sessions and an
+// asciicast v2 recorder for their terminal I/O, replacing the stripped-ANSI
+// text logging that used to live directly in webtty.WebTTY.
+package audit
+
+import (
+	"github.com/rs/zerolog"
+)
+
+// Event is a single structured audit event, e.g. a successful
+// authentication or a line of session I/O.
+type Event struct {
+	// SessionID and Username identify the session the event belongs to.
+	// Username may be empty for anonymous sessions.
+	SessionID string
+	Username  string
+
+	// Stream is "browser" or "backend", mirroring the direction the
+	// webtty text audit log already used, or "session" for a viewer
+	// joining or leaving a shared session.
+	Stream string
+
+	Message string
+}
+
+// Sink receives structured audit events. Implementations must be safe for
+// concurrent use, since a session's browser and backend streams are logged
+// from separate goroutines.
+type Sink interface {
+	Log(Event)
+}
+
+// zerologSink writes events through a zerolog.Logger, so it can be pointed
+// at stdout, a rotating file, or syslog by configuring the writer the
+// Logger was built with.
+type zerologSink struct {
+	logger *zerolog.Logger
+}
+
+// NewSink returns a Sink that writes events as structured logs through
+// logger. The caller is responsible for configuring logger's writer
+// (stdout, file with rotation, syslog, ...).
+func NewSink(logger *zerolog.Logger) Sink {
+	return &zerologSink{logger: logger}
+}
+
+func (s *zerologSink) Log(event Event) {
+	entry := s.logger.Info().
+		Str("log-type", "audit").
+		Str("stream", event.Stream).
+		Str("session-id", event.SessionID)
+	if event.Username != "" {
+		entry = entry.Str("username", event.Username)
+	}
+	entry.Msg(event.Message)
+}
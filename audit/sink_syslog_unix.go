@@ -0,0 +1,22 @@
+//go:build !windows
+// +build !windows
+
+package audit
+
+import (
+	"log/syslog"
+
+	"github.com/rs/zerolog"
+)
+
+// NewSyslogSink returns a Sink that writes events as structured logs to the
+// local syslog daemon under tag, for deployments that centralize logs there
+// instead of a file or stdout.
+func NewSyslogSink(tag string) (Sink, error) {
+	writer, err := syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+	if err != nil {
+		return nil, err
+	}
+	logger := zerolog.New(writer).With().Timestamp().Logger()
+	return NewSink(&logger), nil
+}
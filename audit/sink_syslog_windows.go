@@ -0,0 +1,12 @@
+//go:build windows
+// +build windows
+
+package audit
+
+import "github.com/pkg/errors"
+
+// NewSyslogSink is unavailable on Windows, which has no syslog daemon;
+// use NewFileSink or the Windows Event Log instead.
+func NewSyslogSink(tag string) (Sink, error) {
+	return nil, errors.New("syslog audit sink is not supported on windows")
+}
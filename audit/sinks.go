@@ -0,0 +1,29 @@
+package audit
+
+import (
+	"io"
+	"os"
+
+	"github.com/rs/zerolog"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// NewStdoutSink returns a Sink that writes events as structured logs to
+// stdout, alongside the server's own request logging.
+func NewStdoutSink() Sink {
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	return NewSink(&logger)
+}
+
+// NewFileSink returns a Sink that writes events as structured logs to path,
+// rotating it once it reaches maxSizeMB megabytes and keeping up to
+// maxBackups rotated files.
+func NewFileSink(path string, maxSizeMB, maxBackups int) Sink {
+	writer := &lumberjack.Logger{
+		Filename:   path,
+		MaxSize:    maxSizeMB,
+		MaxBackups: maxBackups,
+	}
+	logger := zerolog.New(io.Writer(writer)).With().Timestamp().Logger()
+	return NewSink(&logger)
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// errNotAuthenticated is returned by basicAuthenticator when the request
+// carries no usable credentials or the credentials don't check out, and by
+// JWTAuthenticator when the request carries no token.
+var errNotAuthenticated = errors.New("not authenticated")
+
+// identityContextKey is the context key under which the Identity resolved by
+// an Authenticator is attached to the request, for handlers further down the
+// chain (e.g. the one that creates the webtty.WebTTY for a connection) to
+// pick up via IdentityFromContext.
+type identityContextKey struct{}
+
+// Identity describes the principal an Authenticator resolved a request to.
+type Identity struct {
+	// Username identifies the caller, e.g. for webtty.WithUsername so audit
+	// logs are keyed by real identity instead of an anonymous session.
+	Username string
+	// PermitWrite grants this specific connection write access. It is
+	// ANDed with the server-wide PermitWrite setting by the caller, so an
+	// Authenticator that doesn't care about per-connection permissions
+	// sets this to true and leaves the decision to the server setting.
+	PermitWrite bool
+}
+
+// Authenticator validates an incoming request and resolves it to an
+// Identity. It returns an error if the request could not be authenticated.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Identity, error)
+}
+
+// IdentityFromContext returns the Identity that wrapAuth attached to ctx, if
+// any.
+func IdentityFromContext(ctx context.Context) (*Identity, bool) {
+	identity, ok := ctx.Value(identityContextKey{}).(*Identity)
+	return identity, ok
+}
+
+// wrapAuth authenticates every request with authenticator, rejecting it with
+// 401 on failure, and otherwise attaches the resolved Identity to the
+// request's context before calling handler.
+func (server *Server) wrapAuth(handler http.Handler, authenticator Authenticator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, err := authenticator.Authenticate(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Basic realm="GoTTY"`)
+			http.Error(w, "authorization failed", http.StatusUnauthorized)
+			return
+		}
+
+		log.Printf("Authentication Succeeded: %s (%s)", r.RemoteAddr, identity.Username)
+		ctx := context.WithValue(r.Context(), identityContextKey{}, identity)
+		handler.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// basicAuthenticator implements Authenticator on top of the existing
+// "user:pass" credential string used by wrapBasicAuth.
+type basicAuthenticator struct {
+	credential string
+}
+
+func (a *basicAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	u, p, ok := r.BasicAuth()
+	if !ok {
+		return nil, errNotAuthenticated
+	}
+	if a.credential != u+":"+p {
+		return nil, errNotAuthenticated
+	}
+
+	return &Identity{Username: u, PermitWrite: true}, nil
+}
@@ -0,0 +1,258 @@
+package server
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/pkg/errors"
+)
+
+// JWTAuthConfig configures JWTAuthenticator. It covers both a bearer token
+// read from an Authorization-style header (OIDC-issued or otherwise) and the
+// two ways its signature can be checked: a JWKS endpoint (RSA-signed tokens
+// only; ES256 and other non-RSA keys are not accepted) or a shared HMAC
+// secret (for tokens minted by a reverse proxy).
+type JWTAuthConfig struct {
+	// Header is the request header the token is read from, e.g.
+	// "Authorization", in which case a "Bearer " prefix is stripped if
+	// present.
+	Header string
+
+	// Issuer and Audience, when non-empty, are checked against the
+	// token's "iss" and "aud" claims.
+	Issuer   string
+	Audience string
+
+	// JWKSURL, when set, is fetched for the provider's signing keys when a
+	// token is validated and the cached keys are older than JWKSRefresh or
+	// lack the token's "kid"; it is not polled in the background. If both
+	// JWKSURL and HMACSecret are set, JWKSURL is used.
+	JWKSURL     string
+	JWKSRefresh time.Duration
+
+	// HMACSecret, when set, verifies HS256-signed tokens instead of
+	// fetching a JWKS.
+	HMACSecret string
+
+	// UsernameClaim is the claim extracted into Identity.Username, e.g.
+	// "email" or "preferred_username".
+	UsernameClaim string
+
+	// PermissionClaim and WriterClaimValues implement the claim-to-
+	// permission mapping: a connection is granted write access only if
+	// the string claim PermissionClaim holds one of WriterClaimValues.
+	// If PermissionClaim is empty, every authenticated connection is
+	// treated as a writer.
+	PermissionClaim   string
+	WriterClaimValues []string
+}
+
+// JWTAuthenticator implements Authenticator by validating a bearer token
+// carried in a request header, per JWTAuthConfig.
+type JWTAuthenticator struct {
+	config JWTAuthConfig
+	keyfunc jwt.Keyfunc
+	jwks    *jwksCache
+}
+
+// NewJWTAuthenticator builds a JWTAuthenticator from config. It returns an
+// error if neither JWKSURL nor HMACSecret is configured.
+func NewJWTAuthenticator(config JWTAuthConfig) (*JWTAuthenticator, error) {
+	if config.Header == "" {
+		config.Header = "Authorization"
+	}
+
+	a := &JWTAuthenticator{config: config}
+
+	switch {
+	case config.JWKSURL != "":
+		refresh := config.JWKSRefresh
+		if refresh <= 0 {
+			refresh = 5 * time.Minute
+		}
+		a.jwks = newJWKSCache(config.JWKSURL, refresh)
+		a.keyfunc = a.jwks.keyfunc
+	case config.HMACSecret != "":
+		secret := []byte(config.HMACSecret)
+		a.keyfunc = func(token *jwt.Token) (interface{}, error) {
+			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, errors.Errorf("unexpected signing method %v", token.Header["alg"])
+			}
+			return secret, nil
+		}
+	default:
+		return nil, errors.New("either JWKSURL or HMACSecret must be set")
+	}
+
+	return a, nil
+}
+
+func (a *JWTAuthenticator) bearerToken(r *http.Request) string {
+	raw := r.Header.Get(a.config.Header)
+	return strings.TrimPrefix(raw, "Bearer ")
+}
+
+// Authenticate implements Authenticator.
+func (a *JWTAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	raw := a.bearerToken(r)
+	if raw == "" {
+		return nil, errNotAuthenticated
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, a.keyfunc)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid token")
+	}
+	if a.config.Issuer != "" && !claims.VerifyIssuer(a.config.Issuer, true) {
+		return nil, errors.New("invalid token: unexpected issuer")
+	}
+	if a.config.Audience != "" && !claims.VerifyAudience(a.config.Audience, true) {
+		return nil, errors.New("invalid token: unexpected audience")
+	}
+
+	username, _ := claims[a.config.UsernameClaim].(string)
+	if username == "" {
+		return nil, errors.Errorf("token has no usable %q claim", a.config.UsernameClaim)
+	}
+
+	return &Identity{
+		Username:    username,
+		PermitWrite: a.permitWrite(claims),
+	}, nil
+}
+
+func (a *JWTAuthenticator) permitWrite(claims jwt.MapClaims) bool {
+	if a.config.PermissionClaim == "" {
+		return true
+	}
+
+	value, _ := claims[a.config.PermissionClaim].(string)
+	for _, writer := range a.config.WriterClaimValues {
+		if value == writer {
+			return true
+		}
+	}
+	return false
+}
+
+// jwksCache fetches and periodically refreshes an RFC 7517 JWKS document,
+// resolving tokens' "kid" header to the matching RSA public key.
+type jwksCache struct {
+	url     string
+	refresh time.Duration
+	client  *http.Client
+
+	mu          sync.RWMutex
+	keys        map[string]*rsa.PublicKey
+	lastFetched time.Time
+}
+
+// jwksFetchTimeout bounds how long a JWKS refresh may take, so a slow or
+// unreachable IdP can't hang the request goroutine validating a token.
+const jwksFetchTimeout = 5 * time.Second
+
+func newJWKSCache(url string, refresh time.Duration) *jwksCache {
+	return &jwksCache{
+		url:     url,
+		refresh: refresh,
+		client:  &http.Client{Timeout: jwksFetchTimeout},
+		keys:    map[string]*rsa.PublicKey{},
+	}
+}
+
+type jwksDocument struct {
+	Keys []struct {
+		Kid string `json:"kid"`
+		Kty string `json:"kty"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+	} `json:"keys"`
+}
+
+func (c *jwksCache) keyfunc(token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+		return nil, errors.Errorf("unexpected signing method %v", token.Header["alg"])
+	}
+
+	kid, _ := token.Header["kid"].(string)
+
+	key, ok := c.lookup(kid)
+	if !ok {
+		if err := c.fetch(); err != nil {
+			return nil, errors.Wrapf(err, "failed to refresh JWKS from %s", c.url)
+		}
+		key, ok = c.lookup(kid)
+	}
+	if !ok {
+		return nil, errors.Errorf("no JWKS key found for kid %q", kid)
+	}
+
+	return key, nil
+}
+
+func (c *jwksCache) lookup(kid string) (*rsa.PublicKey, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if time.Since(c.lastFetched) > c.refresh {
+		return nil, false
+	}
+	key, ok := c.keys[kid]
+	return key, ok
+}
+
+func (c *jwksCache) fetch() error {
+	resp, err := c.client.Get(c.url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var doc jwksDocument
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return err
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(doc.Keys))
+	for _, k := range doc.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		key, err := rsaPublicKeyFromJWK(k.N, k.E)
+		if err != nil {
+			return errors.Wrapf(err, "invalid JWKS entry %q", k.Kid)
+		}
+		keys[k.Kid] = key
+	}
+
+	c.mu.Lock()
+	c.keys = keys
+	c.lastFetched = time.Now()
+	c.mu.Unlock()
+
+	return nil
+}
+
+func rsaPublicKeyFromJWK(nEncoded, eEncoded string) (*rsa.PublicKey, error) {
+	nBytes, err := base64.RawURLEncoding.DecodeString(nEncoded)
+	if err != nil {
+		return nil, err
+	}
+	eBytes, err := base64.RawURLEncoding.DecodeString(eEncoded)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(nBytes),
+		E: int(new(big.Int).SetBytes(eBytes).Int64()),
+	}, nil
+}
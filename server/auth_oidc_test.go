@@ -0,0 +1,115 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func hmacAuthenticator(t *testing.T, secret, permissionClaim string, writerValues ...string) *JWTAuthenticator {
+	t.Helper()
+	a, err := NewJWTAuthenticator(JWTAuthConfig{
+		HMACSecret:        secret,
+		UsernameClaim:     "sub",
+		PermissionClaim:   permissionClaim,
+		WriterClaimValues: writerValues,
+	})
+	if err != nil {
+		t.Fatalf("NewJWTAuthenticator: %v", err)
+	}
+	return a
+}
+
+func signHS256(t *testing.T, secret string, claims jwt.MapClaims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	if err != nil {
+		t.Fatalf("SignedString: %v", err)
+	}
+	return token
+}
+
+func TestJWTAuthenticatorAcceptsValidHMACToken(t *testing.T) {
+	a := hmacAuthenticator(t, "sekret", "perm", "writer")
+	token := signHS256(t, "sekret", jwt.MapClaims{
+		"sub":  "alice",
+		"perm": "writer",
+		"exp":  time.Now().Add(time.Hour).Unix(),
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+
+	identity, err := a.Authenticate(r)
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if identity.Username != "alice" {
+		t.Errorf("Username = %q, want %q", identity.Username, "alice")
+	}
+	if !identity.PermitWrite {
+		t.Errorf("PermitWrite = false, want true for claim %q=writer", "perm")
+	}
+}
+
+func TestJWTAuthenticatorRejectsWrongSecret(t *testing.T) {
+	a := hmacAuthenticator(t, "sekret", "")
+	token := signHS256(t, "wrong-secret", jwt.MapClaims{"sub": "alice"})
+
+	r := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+
+	if _, err := a.Authenticate(r); err == nil {
+		t.Fatal("Authenticate succeeded with a token signed by the wrong secret")
+	}
+}
+
+func TestJWTAuthenticatorRejectsAlgorithmConfusion(t *testing.T) {
+	a := hmacAuthenticator(t, "sekret", "")
+
+	// A token claiming "alg: none" must never be accepted regardless of
+	// what HMACSecret is configured with.
+	unsigned := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{"sub": "alice"})
+	token, err := unsigned.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatalf("SignedString: %v", err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+
+	if _, err := a.Authenticate(r); err == nil {
+		t.Fatal("Authenticate succeeded with an alg=none token")
+	}
+}
+
+func TestJWTAuthenticatorPermitWriteRequiresMatchingClaim(t *testing.T) {
+	a := hmacAuthenticator(t, "sekret", "perm", "writer")
+	token := signHS256(t, "sekret", jwt.MapClaims{"sub": "bob", "perm": "reader"})
+
+	r := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+
+	identity, err := a.Authenticate(r)
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if identity.PermitWrite {
+		t.Error("PermitWrite = true, want false for a non-writer claim value")
+	}
+}
+
+func TestJWTAuthenticatorRejectsMissingUsernameClaim(t *testing.T) {
+	a := hmacAuthenticator(t, "sekret", "")
+	token := signHS256(t, "sekret", jwt.MapClaims{"email": "alice@example.com"})
+
+	r := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+
+	if _, err := a.Authenticate(r); err == nil {
+		t.Fatal("Authenticate succeeded despite a missing sub claim")
+	}
+}
@@ -0,0 +1,31 @@
+package server
+
+import "github.com/gorilla/websocket"
+
+// defaultCompressionLevel is the permessage-deflate level enableCompression
+// sets on every connection, trading a little CPU for the 3-10x gotty sees
+// compressing typical shell output (log/ls dumps, repeated prompts).
+const defaultCompressionLevel = 4
+
+// newUpgrader builds the websocket.Upgrader used to accept GoTTY
+// connections, with permessage-deflate enabled so compression is available
+// end to end whenever both sides negotiate it.
+func newUpgrader() *websocket.Upgrader {
+	upgrader := &websocket.Upgrader{
+		ReadBufferSize:    1024,
+		WriteBufferSize:   1024,
+		Subprotocols:      []string{"gotty"},
+		EnableCompression: true,
+	}
+	return upgrader
+}
+
+// enableCompression turns on permessage-deflate writes for an
+// already-established connection at defaultCompressionLevel. It is called
+// right after the upgrade; compression only takes effect if the client
+// negotiated permessage-deflate in the websocket handshake, and the
+// SetCapabilities message has no bearing on it.
+func enableCompression(conn *websocket.Conn) {
+	conn.EnableWriteCompression(true)
+	conn.SetCompressionLevel(defaultCompressionLevel)
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// wrapIPACL enforces deny-then-allow CIDR rules on the effective client IP:
+// a request is rejected if it matches any entry in deny, then, if allow is
+// non-empty, rejected unless it also matches an entry there. The direct
+// RemoteAddr is trusted as-is unless it falls within trustedProxies, in
+// which case X-Forwarded-For/X-Real-IP is consulted instead, so a client
+// can't spoof its way past the ACL by setting those headers itself.
+func (server *Server) wrapIPACL(handler http.Handler, allow, deny, trustedProxies []*net.IPNet) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := effectiveClientIP(r, trustedProxies)
+		if ip == nil {
+			log.Printf("IP ACL: rejecting %s: could not parse client IP", r.RemoteAddr)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		if ipInAny(ip, deny) {
+			log.Printf("IP ACL: denying %s (%s): matched deny list", r.RemoteAddr, ip)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		if len(allow) > 0 && !ipInAny(ip, allow) {
+			log.Printf("IP ACL: denying %s (%s): not in allow list", r.RemoteAddr, ip)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// effectiveClientIP returns the IP the ACL should evaluate: RemoteAddr,
+// unless it falls within trustedProxies, in which case the left-most
+// (original client) address from X-Forwarded-For, falling back to
+// X-Real-IP, is used instead.
+func effectiveClientIP(r *http.Request, trustedProxies []*net.IPNet) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	remote := net.ParseIP(host)
+	if remote == nil {
+		return nil
+	}
+
+	if !ipInAny(remote, trustedProxies) {
+		return remote
+	}
+
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		first := strings.TrimSpace(strings.Split(xff, ",")[0])
+		if ip := net.ParseIP(first); ip != nil {
+			return ip
+		}
+	}
+
+	if xri := r.Header.Get("X-Real-IP"); xri != "" {
+		if ip := net.ParseIP(strings.TrimSpace(xri)); ip != nil {
+			return ip
+		}
+	}
+
+	return remote
+}
+
+func ipInAny(ip net.IP, nets []*net.IPNet) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func mustParseCIDRs(t *testing.T, values ...string) []*net.IPNet {
+	t.Helper()
+	nets, err := parseCIDRs(values)
+	if err != nil {
+		t.Fatalf("parseCIDRs(%v): %v", values, err)
+	}
+	return nets
+}
+
+func TestEffectiveClientIPTrustsProxyHeaderOnlyFromTrustedProxy(t *testing.T) {
+	trusted := mustParseCIDRs(t, "10.0.0.0/8")
+
+	r := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	r.RemoteAddr = "10.0.0.1:12345"
+	r.Header.Set("X-Forwarded-For", "203.0.113.7, 10.0.0.1")
+
+	ip := effectiveClientIP(r, trusted)
+	if ip == nil || ip.String() != "203.0.113.7" {
+		t.Errorf("effectiveClientIP = %v, want 203.0.113.7", ip)
+	}
+}
+
+func TestEffectiveClientIPIgnoresHeaderFromUntrustedPeer(t *testing.T) {
+	trusted := mustParseCIDRs(t, "10.0.0.0/8")
+
+	r := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	r.RemoteAddr = "203.0.113.7:12345"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+
+	ip := effectiveClientIP(r, trusted)
+	if ip == nil || ip.String() != "203.0.113.7" {
+		t.Errorf("effectiveClientIP = %v, want the untrusted peer's own address 203.0.113.7", ip)
+	}
+}
+
+func TestWrapIPACLDenyTakesPrecedenceOverAllow(t *testing.T) {
+	server := &Server{}
+	allow := mustParseCIDRs(t, "203.0.113.0/24")
+	deny := mustParseCIDRs(t, "203.0.113.7/32")
+
+	called := false
+	handler := server.wrapIPACL(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}), allow, deny, nil)
+
+	r := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	r.RemoteAddr = "203.0.113.7:12345"
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if called {
+		t.Error("handler was called for an IP on the deny list")
+	}
+	if w.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
+
+func TestWrapIPACLRejectsIPNotInAllowList(t *testing.T) {
+	server := &Server{}
+	allow := mustParseCIDRs(t, "203.0.113.0/24")
+
+	called := false
+	handler := server.wrapIPACL(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}), allow, nil, nil)
+
+	r := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	r.RemoteAddr = "198.51.100.1:12345"
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if called {
+		t.Error("handler was called for an IP outside the allow list")
+	}
+	if w.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
+
+func TestWrapIPACLAllowsMatchingIP(t *testing.T) {
+	server := &Server{}
+	allow := mustParseCIDRs(t, "203.0.113.0/24")
+
+	called := false
+	handler := server.wrapIPACL(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		w.WriteHeader(http.StatusOK)
+	}), allow, nil, nil)
+
+	r := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	r.RemoteAddr = "203.0.113.42:12345"
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if !called {
+		t.Error("handler was not called for an IP within the allow list")
+	}
+	if w.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
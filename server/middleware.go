@@ -1,7 +1,6 @@
 package server
 
 import (
-	"fmt"
 	"log"
 	"net/http"
 )
@@ -23,19 +22,5 @@ func (server *Server) wrapHeaders(handler http.Handler) http.Handler {
 }
 
 func (server *Server) wrapBasicAuth(handler http.Handler, credential string) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		u, p, ok := r.BasicAuth()
-		if !ok {
-			w.Header().Set("WWW-Authenticate", `Basic realm="GoTTY"`)
-			http.Error(w, "Bad Request", http.StatusUnauthorized)
-			return
-		}
-		if credential != fmt.Sprintf("%s:%s", u, p) {
-			http.Error(w, "authorization failed", http.StatusUnauthorized)
-			return
-		}
-
-		log.Printf("Basic Authentication Succeeded: %s", r.RemoteAddr)
-		handler.ServeHTTP(w, r)
-	})
+	return server.wrapAuth(handler, &basicAuthenticator{credential: credential})
 }
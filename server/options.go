@@ -0,0 +1,69 @@
+package server
+
+import "github.com/urfave/cli/v2"
+
+// Options holds server-wide configuration assembled from CLI flags. Flags()
+// returns the urfave/cli flag set an application entrypoint registers to
+// populate it.
+type Options struct {
+	Address     string
+	Port        string
+	PermitWrite bool
+
+	// Credential enables HTTP Basic Authentication when non-empty, e.g.
+	// "user:pass".
+	Credential string
+
+	// OIDC/JWT bearer-token authentication, alongside basic auth.
+	AuthHeader            string
+	AuthOIDCIssuer        string
+	AuthOIDCClientID      string
+	AuthOIDCAudience      string
+	AuthJWTJWKSURL        string
+	AuthJWTHMACSecret     string
+	AuthUsernameClaim     string
+	AuthPermissionClaim   string
+	AuthWriterClaimValues cli.StringSlice
+
+	// RecordDir, when set, makes every session recorded as an asciicast
+	// v2 file named by session id and username under that directory.
+	RecordDir string
+
+	// Forward whitelists "host:port" targets that a permitWrite session
+	// may open local port-forwarding channels to.
+	Forward cli.StringSlice
+
+	// AllowCIDR/DenyCIDR/TrustedProxy configure wrapIPACL.
+	AllowCIDR    cli.StringSlice
+	DenyCIDR     cli.StringSlice
+	TrustedProxy cli.StringSlice
+}
+
+// Flags returns the CLI flags that populate options through their
+// Destination fields.
+func Flags(options *Options) []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{Name: "address", Aliases: []string{"a"}, Value: "0.0.0.0", Usage: "IP address to listen", Destination: &options.Address},
+		&cli.StringFlag{Name: "port", Aliases: []string{"p"}, Value: "8080", Usage: "Port number to listen", Destination: &options.Port},
+		&cli.BoolFlag{Name: "permit-write", Aliases: []string{"w"}, Usage: "Permit clients to write to the TTY (BE CAREFUL)", Destination: &options.PermitWrite},
+		&cli.StringFlag{Name: "credential", Aliases: []string{"c"}, Usage: "Credential for Basic Authentication, e.g. \"user:pass\"", Destination: &options.Credential},
+
+		&cli.StringFlag{Name: "auth-header", Usage: "Header a reverse proxy injects a bearer token into, e.g. \"Authorization\"", Destination: &options.AuthHeader},
+		&cli.StringFlag{Name: "auth-oidc-issuer", Usage: "Expected \"iss\" claim of OIDC-issued tokens", Destination: &options.AuthOIDCIssuer},
+		&cli.StringFlag{Name: "auth-oidc-client-id", Usage: "OIDC client id", Destination: &options.AuthOIDCClientID},
+		&cli.StringFlag{Name: "auth-oidc-audience", Usage: "Expected \"aud\" claim of OIDC-issued tokens", Destination: &options.AuthOIDCAudience},
+		&cli.StringFlag{Name: "auth-jwt-jwks-url", Usage: "JWKS URL used to verify bearer token signatures", Destination: &options.AuthJWTJWKSURL},
+		&cli.StringFlag{Name: "auth-jwt-hmac-secret", Usage: "Shared secret used to verify HS256 bearer tokens, instead of a JWKS", Destination: &options.AuthJWTHMACSecret},
+		&cli.StringFlag{Name: "auth-username-claim", Value: "sub", Usage: "Claim propagated into webtty.WithUsername for audit logging", Destination: &options.AuthUsernameClaim},
+		&cli.StringFlag{Name: "auth-permission-claim", Usage: "Claim checked against --auth-writer-claim-value to grant write access", Destination: &options.AuthPermissionClaim},
+		&cli.StringSliceFlag{Name: "auth-writer-claim-value", Usage: "Claim value(s) that grant write access (repeatable)", Destination: &options.AuthWriterClaimValues},
+
+		&cli.StringFlag{Name: "record-dir", Usage: "Directory asciicast v2 recordings of each session are written to", Destination: &options.RecordDir},
+
+		&cli.StringSliceFlag{Name: "forward", Usage: "Whitelist a \"host:port\" target for local port-forwarding channels (repeatable)", Destination: &options.Forward},
+
+		&cli.StringSliceFlag{Name: "allow-cidr", Usage: "Allow client IPs within this CIDR range (repeatable)", Destination: &options.AllowCIDR},
+		&cli.StringSliceFlag{Name: "deny-cidr", Usage: "Deny client IPs within this CIDR range, checked before --allow-cidr (repeatable)", Destination: &options.DenyCIDR},
+		&cli.StringSliceFlag{Name: "trusted-proxy", Usage: "Trust X-Forwarded-For/X-Real-IP from this CIDR range (repeatable)", Destination: &options.TrustedProxy},
+	}
+}
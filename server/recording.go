@@ -0,0 +1,24 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// openRecordingFile creates (or truncates) "<dir>/<name>.cast" for an
+// asciicast v2 recording, creating dir if it doesn't already exist.
+func openRecordingFile(dir, name string) (*os.File, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrapf(err, "failed to create record dir %q", dir)
+	}
+
+	path := filepath.Join(dir, name+".cast")
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create recording file %q", path)
+	}
+
+	return f, nil
+}
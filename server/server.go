@@ -0,0 +1,457 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+	"github.com/sorenisanerd/gotty/audit"
+	"github.com/sorenisanerd/gotty/webtty"
+)
+
+// Factory creates the Slave (PTY) a new session is backed by. Params are
+// the incoming request's query parameters, e.g. for passing arguments
+// through to the spawned command.
+type Factory interface {
+	New(params map[string][]string) (webtty.Slave, error)
+}
+
+// Server is a GoTTY server. It owns the websocket upgrader, the optional
+// Authenticator built from Options, the parsed IP ACL, and the registry of
+// SessionHubs backing shared sessions.
+type Server struct {
+	factory Factory
+	options *Options
+
+	upgrader      *websocket.Upgrader
+	authenticator Authenticator
+
+	allowNets, denyNets, trustedProxyNets []*net.IPNet
+
+	auditSink audit.Sink
+
+	joinSecret []byte
+
+	mu   sync.Mutex
+	hubs map[string]*webtty.SessionHub
+}
+
+// New builds a Server from factory and options, constructing whichever of
+// the auth/ACL/audit subsystems the options enable.
+func New(factory Factory, options *Options) (*Server, error) {
+	server := &Server{
+		factory:  factory,
+		options:  options,
+		upgrader: newUpgrader(),
+		hubs:     make(map[string]*webtty.SessionHub),
+	}
+
+	authenticator, err := server.buildAuthenticator()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to configure authentication")
+	}
+	server.authenticator = authenticator
+
+	if server.allowNets, err = parseCIDRs(options.AllowCIDR.Value()); err != nil {
+		return nil, errors.Wrapf(err, "invalid --allow-cidr")
+	}
+	if server.denyNets, err = parseCIDRs(options.DenyCIDR.Value()); err != nil {
+		return nil, errors.Wrapf(err, "invalid --deny-cidr")
+	}
+	if server.trustedProxyNets, err = parseCIDRs(options.TrustedProxy.Value()); err != nil {
+		return nil, errors.Wrapf(err, "invalid --trusted-proxy")
+	}
+
+	if options.RecordDir != "" {
+		server.auditSink = audit.NewFileSink(filepath.Join(options.RecordDir, "audit.log"), 100, 10)
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, errors.Wrapf(err, "failed to generate session join secret")
+	}
+	server.joinSecret = secret
+
+	return server, nil
+}
+
+func (server *Server) buildAuthenticator() (Authenticator, error) {
+	options := server.options
+
+	if options.AuthJWTJWKSURL != "" || options.AuthJWTHMACSecret != "" {
+		header := options.AuthHeader
+		if header == "" {
+			header = "Authorization"
+		}
+		usernameClaim := options.AuthUsernameClaim
+		if usernameClaim == "" {
+			usernameClaim = "sub"
+		}
+
+		// The OIDC client id is the conventional audience for tokens
+		// issued to this app; fall back to it when --auth-oidc-audience
+		// isn't set explicitly.
+		audience := options.AuthOIDCAudience
+		if audience == "" {
+			audience = options.AuthOIDCClientID
+		}
+
+		return NewJWTAuthenticator(JWTAuthConfig{
+			Header:            header,
+			Issuer:            options.AuthOIDCIssuer,
+			Audience:          audience,
+			JWKSURL:           options.AuthJWTJWKSURL,
+			HMACSecret:        options.AuthJWTHMACSecret,
+			UsernameClaim:     usernameClaim,
+			PermissionClaim:   options.AuthPermissionClaim,
+			WriterClaimValues: options.AuthWriterClaimValues.Value(),
+		})
+	}
+
+	if options.Credential != "" {
+		return &basicAuthenticator{credential: options.Credential}, nil
+	}
+
+	return nil, nil
+}
+
+func parseCIDRs(values []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(values))
+	for _, v := range values {
+		_, ipNet, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid CIDR %q", v)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// Handlers builds the server's full middleware chain around its websocket
+// endpoints.
+func (server *Server) Handlers() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", server.handleWS)
+	mux.HandleFunc("/s/", server.handleSharedWS)
+	mux.HandleFunc("/invite", server.handleInvite)
+
+	var handler http.Handler = mux
+	handler = server.wrapHeaders(handler)
+
+	if server.authenticator != nil {
+		handler = server.wrapAuth(handler, server.authenticator)
+	}
+
+	if len(server.allowNets) > 0 || len(server.denyNets) > 0 {
+		handler = server.wrapIPACL(handler, server.allowNets, server.denyNets, server.trustedProxyNets)
+	}
+
+	handler = server.wrapLogger(handler)
+
+	return handler
+}
+
+// handleWS upgrades a new primary connection, spawns its Slave, and runs
+// the SessionHub that owns it so later requests to /s/{sessionID} can
+// attach as additional viewers.
+func (server *Server) handleWS(w http.ResponseWriter, r *http.Request) {
+	identity, _ := IdentityFromContext(r.Context())
+	username := ""
+	permitWrite := server.options.PermitWrite
+	if identity != nil {
+		username = identity.Username
+		permitWrite = permitWrite && identity.PermitWrite
+	}
+
+	slave, err := server.factory.New(r.URL.Query())
+	if err != nil {
+		log.Printf("Failed to create slave: %s", err)
+		http.Error(w, "failed to start session", http.StatusInternalServerError)
+		return
+	}
+
+	sessionID := generateSessionID()
+
+	// The primary connection is the session's creator, so its own join
+	// token (handed back for it to use minting invites via /invite) is
+	// RoleOwner when it can write, and RoleReadOnly otherwise.
+	role := webtty.RoleReadOnly
+	if permitWrite {
+		role = webtty.RoleOwner
+	}
+
+	responseHeader := http.Header{}
+	responseHeader.Set("X-GoTTY-Session-Id", sessionID)
+	if token, err := SignJoinToken(server.joinSecret, sessionID, username, role, 24*time.Hour); err == nil {
+		responseHeader.Set("X-GoTTY-Join-Token", token)
+	}
+
+	conn, err := server.upgrader.Upgrade(w, r, responseHeader)
+	if err != nil {
+		log.Printf("Failed to upgrade connection: %s", err)
+		slave.Close()
+		return
+	}
+	enableCompression(conn)
+	defer conn.Close()
+	defer slave.Close()
+
+	var recorder *audit.Recorder
+	if server.options.RecordDir != "" {
+		recorder, err = server.newRecorder(sessionID, username)
+		if err != nil {
+			log.Printf("Failed to open recording for session %s: %s", sessionID, err)
+		} else {
+			defer recorder.Close()
+		}
+	}
+
+	hub := webtty.NewSessionHub(slave,
+		webtty.WithHubSessionID(sessionID),
+		webtty.WithHubAuditSink(server.auditSink),
+		webtty.WithHubRecorder(recorder),
+	)
+	server.registerHub(sessionID, hub)
+	defer server.unregisterHub(sessionID)
+
+	opts := []webtty.Option{
+		webtty.WithSessionID(sessionID),
+		webtty.WithUsername(username),
+		webtty.WithPermitWrite(permitWrite),
+		webtty.WithAuditSink(server.auditSink),
+		webtty.WithRecorder(recorder),
+		webtty.WithSessionHub(hub),
+	}
+	if permitWrite && len(server.options.Forward.Value()) > 0 {
+		// Port-forwarding channels are only meaningful for the
+		// connection that's also allowed to drive the shell.
+		opts = append(opts, webtty.WithForwardTargets(server.options.Forward.Value()))
+	}
+
+	tty, err := webtty.New(&wsWrapper{conn: conn}, slave, opts...)
+	if err != nil {
+		log.Printf("Failed to create webtty: %s", err)
+		return
+	}
+
+	if err := tty.Run(r.Context()); err != nil && err != context.Canceled {
+		log.Printf("Session %s ended: %s", sessionID, err)
+	}
+}
+
+// handleSharedWS lets an additional viewer attach to an already-running
+// session named by the /s/{sessionID} path, after verifying its signed
+// join token.
+func (server *Server) handleSharedWS(w http.ResponseWriter, r *http.Request) {
+	sessionID := strings.TrimPrefix(r.URL.Path, "/s/")
+	if sessionID == "" {
+		http.Error(w, "missing session id", http.StatusBadRequest)
+		return
+	}
+
+	role, username, err := VerifyJoinToken(server.joinSecret, sessionID, r.URL.Query().Get("token"))
+	if err != nil {
+		http.Error(w, "invalid join token", http.StatusForbidden)
+		return
+	}
+
+	hub, ok := server.lookupHub(sessionID)
+	if !ok {
+		http.Error(w, "no such session", http.StatusNotFound)
+		return
+	}
+
+	conn, err := server.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("Failed to upgrade connection: %s", err)
+		return
+	}
+	enableCompression(conn)
+	defer conn.Close()
+
+	server.pumpViewer(r.Context(), hub, &wsWrapper{conn: conn}, role, username)
+}
+
+// handleInvite mints a join token for an already-running session at a
+// role no higher than the caller's own, so an owner or writer can hand
+// out read-only (or writer) invite links without giving away their own
+// token. The caller authenticates by presenting a token that's already
+// valid for the session.
+func (server *Server) handleInvite(w http.ResponseWriter, r *http.Request) {
+	sessionID := r.URL.Query().Get("session_id")
+	if sessionID == "" {
+		http.Error(w, "missing session_id", http.StatusBadRequest)
+		return
+	}
+
+	callerRole, username, err := VerifyJoinToken(server.joinSecret, sessionID, r.URL.Query().Get("token"))
+	if err != nil {
+		http.Error(w, "invalid join token", http.StatusForbidden)
+		return
+	}
+
+	if _, ok := server.lookupHub(sessionID); !ok {
+		http.Error(w, "no such session", http.StatusNotFound)
+		return
+	}
+
+	role := callerRole
+	if v := r.URL.Query().Get("role"); v != "" {
+		requested, err := parseRole(v)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if requested > callerRole {
+			http.Error(w, "cannot invite a role higher than your own", http.StatusForbidden)
+			return
+		}
+		role = requested
+	}
+
+	inviteUsername := username
+	if v := r.URL.Query().Get("username"); v != "" {
+		inviteUsername = v
+	}
+
+	token, err := SignJoinToken(server.joinSecret, sessionID, inviteUsername, role, 24*time.Hour)
+	if err != nil {
+		log.Printf("Failed to sign invite token: %s", err)
+		http.Error(w, "failed to sign join token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(token))
+}
+
+// pumpViewer attaches conn to hub and relays its frames until it closes.
+func (server *Server) pumpViewer(ctx context.Context, hub *webtty.SessionHub, conn webtty.Master, role webtty.Role, username string) {
+	id, detach, err := hub.Attach(conn, role, username)
+	if err != nil {
+		log.Printf("Failed to attach viewer: %s", err)
+		return
+	}
+	defer detach()
+
+	buffer := make([]byte, 4096)
+	for {
+		n, err := conn.Read(buffer)
+		if err != nil {
+			return
+		}
+		if err := hub.HandleMasterFrame(id, buffer[:n]); err != nil {
+			log.Printf("Error handling frame from %s: %s", username, err)
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+	}
+}
+
+func (server *Server) registerHub(sessionID string, hub *webtty.SessionHub) {
+	server.mu.Lock()
+	server.hubs[sessionID] = hub
+	server.mu.Unlock()
+}
+
+func (server *Server) unregisterHub(sessionID string) {
+	server.mu.Lock()
+	delete(server.hubs, sessionID)
+	server.mu.Unlock()
+}
+
+func (server *Server) lookupHub(sessionID string) (*webtty.SessionHub, bool) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	hub, ok := server.hubs[sessionID]
+	return hub, ok
+}
+
+func (server *Server) newRecorder(sessionID, username string) (*audit.Recorder, error) {
+	name := sessionID
+	if username != "" {
+		name = sessionID + "-" + username
+	}
+	f, err := openRecordingFile(server.options.RecordDir, name)
+	if err != nil {
+		return nil, err
+	}
+	return audit.NewRecorder(f, 80, 24, name, nil)
+}
+
+func generateSessionID() string {
+	buf := make([]byte, 16)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// wsWrapper adapts a *websocket.Conn to the io.Reader/io.Writer pair
+// webtty.Master requires, reading and writing whole websocket messages.
+type wsWrapper struct {
+	conn *websocket.Conn
+
+	mu      sync.Mutex
+	pending []byte
+}
+
+func (w *wsWrapper) Read(p []byte) (int, error) {
+	if len(w.pending) == 0 {
+		_, data, err := w.conn.ReadMessage()
+		if err != nil {
+			return 0, err
+		}
+		w.pending = data
+	}
+
+	if len(w.pending) > len(p) {
+		// webtty's master-read loop treats every Read as one complete
+		// frame; handing back a truncated prefix here and the rest on
+		// the next call would make that remainder look like a fresh
+		// frame missing its leading type byte. Surface the mismatch
+		// instead of silently splitting the message.
+		n := len(w.pending)
+		w.pending = nil
+		return 0, errors.Errorf("websocket message of %d bytes exceeds read buffer of %d bytes", n, len(p))
+	}
+
+	n := copy(p, w.pending)
+	w.pending = nil
+	return n, nil
+}
+
+func (w *wsWrapper) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.conn.WriteMessage(websocket.TextMessage, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// WriteBinary implements webtty.BinaryWriter, letting a negotiated binary
+// session send a real binary websocket frame instead of the legacy text
+// protocol.
+func (w *wsWrapper) WriteBinary(data []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.conn.WriteMessage(websocket.BinaryMessage, data)
+}
+
+var _ io.ReadWriter = (*wsWrapper)(nil)
+var _ webtty.BinaryWriter = (*wsWrapper)(nil)
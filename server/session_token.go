@@ -0,0 +1,69 @@
+package server
+
+import (
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/pkg/errors"
+	"github.com/sorenisanerd/gotty/webtty"
+)
+
+// joinClaims are the claims embedded in the signed token a client presents
+// to attach to a shared session at /s/{sessionID}.
+type joinClaims struct {
+	jwt.RegisteredClaims
+	SessionID string      `json:"sid"`
+	Role      webtty.Role `json:"role"`
+	Username  string      `json:"username"`
+}
+
+// SignJoinToken mints a join token for sessionID that grants role to
+// username, valid for ttl, signed with secret. The server mints these for a
+// session's primary connection (handleWS) and for invites (handleInvite),
+// and VerifyJoinToken checks them when a viewer attaches.
+func SignJoinToken(secret []byte, sessionID, username string, role webtty.Role, ttl time.Duration) (string, error) {
+	claims := joinClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
+		},
+		SessionID: sessionID,
+		Role:      role,
+		Username:  username,
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+}
+
+// VerifyJoinToken checks that token is a valid, unexpired join token for
+// sessionID signed with secret, and returns the role and username it
+// grants.
+func VerifyJoinToken(secret []byte, sessionID, token string) (role webtty.Role, username string, err error) {
+	claims := &joinClaims{}
+	_, err = jwt.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) {
+		return secret, nil
+	})
+	if err != nil {
+		return 0, "", errors.Wrapf(err, "invalid join token")
+	}
+
+	if claims.SessionID != sessionID {
+		return 0, "", errors.Errorf("join token is not valid for session %q", sessionID)
+	}
+
+	return claims.Role, claims.Username, nil
+}
+
+// parseRole parses the role names accepted by the /invite endpoint's
+// "role" query parameter into a webtty.Role.
+func parseRole(s string) (webtty.Role, error) {
+	switch s {
+	case "readonly", "read-only":
+		return webtty.RoleReadOnly, nil
+	case "writer":
+		return webtty.RoleWriter, nil
+	case "owner":
+		return webtty.RoleOwner, nil
+	default:
+		return 0, errors.Errorf("unknown role %q", s)
+	}
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sorenisanerd/gotty/webtty"
+)
+
+func TestSignAndVerifyJoinTokenRoundTrip(t *testing.T) {
+	secret := []byte("join-secret")
+
+	token, err := SignJoinToken(secret, "session-1", "alice", webtty.RoleWriter, time.Hour)
+	if err != nil {
+		t.Fatalf("SignJoinToken: %v", err)
+	}
+
+	role, username, err := VerifyJoinToken(secret, "session-1", token)
+	if err != nil {
+		t.Fatalf("VerifyJoinToken: %v", err)
+	}
+	if role != webtty.RoleWriter {
+		t.Errorf("role = %v, want %v", role, webtty.RoleWriter)
+	}
+	if username != "alice" {
+		t.Errorf("username = %q, want %q", username, "alice")
+	}
+}
+
+func TestVerifyJoinTokenRejectsWrongSession(t *testing.T) {
+	secret := []byte("join-secret")
+
+	token, err := SignJoinToken(secret, "session-1", "alice", webtty.RoleOwner, time.Hour)
+	if err != nil {
+		t.Fatalf("SignJoinToken: %v", err)
+	}
+
+	if _, _, err := VerifyJoinToken(secret, "session-2", token); err == nil {
+		t.Fatal("VerifyJoinToken accepted a token minted for a different session id")
+	}
+}
+
+func TestVerifyJoinTokenRejectsWrongSecret(t *testing.T) {
+	token, err := SignJoinToken([]byte("join-secret"), "session-1", "alice", webtty.RoleOwner, time.Hour)
+	if err != nil {
+		t.Fatalf("SignJoinToken: %v", err)
+	}
+
+	if _, _, err := VerifyJoinToken([]byte("other-secret"), "session-1", token); err == nil {
+		t.Fatal("VerifyJoinToken accepted a token signed with a different secret")
+	}
+}
+
+func TestVerifyJoinTokenRejectsExpiredToken(t *testing.T) {
+	secret := []byte("join-secret")
+
+	token, err := SignJoinToken(secret, "session-1", "alice", webtty.RoleOwner, -time.Minute)
+	if err != nil {
+		t.Fatalf("SignJoinToken: %v", err)
+	}
+
+	if _, _, err := VerifyJoinToken(secret, "session-1", token); err == nil {
+		t.Fatal("VerifyJoinToken accepted an already-expired token")
+	}
+}
+
+func TestParseRole(t *testing.T) {
+	cases := map[string]webtty.Role{
+		"readonly":  webtty.RoleReadOnly,
+		"read-only": webtty.RoleReadOnly,
+		"writer":    webtty.RoleWriter,
+		"owner":     webtty.RoleOwner,
+	}
+	for in, want := range cases {
+		got, err := parseRole(in)
+		if err != nil {
+			t.Errorf("parseRole(%q): %v", in, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("parseRole(%q) = %v, want %v", in, got, want)
+		}
+	}
+
+	if _, err := parseRole("superuser"); err == nil {
+		t.Error("parseRole(\"superuser\") did not return an error")
+	}
+}
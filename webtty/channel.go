@@ -0,0 +1,216 @@
+package webtty
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// OpenChannel, ChannelData and CloseChannel multiplex local port-forwarding
+// channels over the same websocket a WebTTY session already uses, following
+// the shape of SSH direct-tcpip channels: the client opens a channel to a
+// server-whitelisted target, then streams ChannelData frames both ways
+// until either side sends CloseChannel. Like SetCapabilities and the
+// SessionHub message types, they're chosen outside the digit range the
+// legacy protocol uses.
+const (
+	OpenChannel  = 'o'
+	ChannelData  = 'd'
+	CloseChannel = 'x'
+)
+
+// argOpenChannel is the JSON payload of an OpenChannel message.
+type argOpenChannel struct {
+	ChannelID int    `json:"channel_id"`
+	Target    string `json:"target"` // "host:port"
+}
+
+// argCloseChannel is the JSON payload of a CloseChannel message.
+type argCloseChannel struct {
+	ChannelID int `json:"channel_id"`
+}
+
+// argChannelData is the JSON payload of a ChannelData message. Data is
+// base64-encoded, since forwarded TCP payloads (DB wire protocols, other
+// websocket frames, ...) are arbitrary bytes and JSON strings must be
+// valid UTF-8.
+type argChannelData struct {
+	ChannelID int    `json:"channel_id"`
+	Data      string `json:"data"`
+}
+
+// channel is one forwarded TCP connection multiplexed over the websocket.
+type channel struct {
+	id   int
+	conn net.Conn
+}
+
+// channelMux dials locally-forwarded TCP targets on behalf of the browser
+// and multiplexes their traffic as ChannelData frames over a single
+// WebTTY's masterConn, so a remote shell can also reach e.g. a Jupyter or
+// TensorBoard instance on the same host without opening extra ports.
+type channelMux struct {
+	wt *WebTTY
+
+	allowed []string // "host:port" targets permitted by --forward
+
+	mu       sync.Mutex
+	channels map[int]*channel
+}
+
+// newChannelMux creates a channelMux that only dials targets in allowed.
+func newChannelMux(wt *WebTTY, allowed []string) *channelMux {
+	return &channelMux{
+		wt:       wt,
+		allowed:  allowed,
+		channels: make(map[int]*channel),
+	}
+}
+
+func (m *channelMux) isAllowed(target string) bool {
+	for _, a := range m.allowed {
+		if a == target {
+			return true
+		}
+	}
+	return false
+}
+
+// HandleOpenChannel dials args.Target, provided it's whitelisted, and
+// starts streaming its reads back to the master as ChannelData frames.
+func (m *channelMux) HandleOpenChannel(data []byte) error {
+	var args argOpenChannel
+	if err := json.Unmarshal(data, &args); err != nil {
+		return errors.Wrapf(err, "received malformed open-channel message")
+	}
+
+	if !m.isAllowed(args.Target) {
+		return m.closeChannel(args.ChannelID, errors.Errorf("target %q is not in --forward whitelist", args.Target))
+	}
+
+	conn, err := net.Dial("tcp", args.Target)
+	if err != nil {
+		return m.closeChannel(args.ChannelID, errors.Wrapf(err, "failed to dial forwarded target %q", args.Target))
+	}
+
+	ch := &channel{id: args.ChannelID, conn: conn}
+	m.mu.Lock()
+	m.channels[ch.id] = ch
+	m.mu.Unlock()
+
+	go m.pump(ch)
+
+	return nil
+}
+
+// pump copies conn reads to the master as ChannelData frames until conn is
+// closed or errors, then tears the channel down.
+func (m *channelMux) pump(ch *channel) {
+	defer m.removeChannel(ch.id)
+	defer ch.conn.Close()
+
+	buffer := make([]byte, m.wt.bufferSize)
+	for {
+		n, err := ch.conn.Read(buffer)
+		if n > 0 {
+			frame, marshalErr := m.dataFrame(ch.id, buffer[:n])
+			if marshalErr == nil {
+				_ = m.wt.masterWrite(frame)
+			}
+		}
+		if err != nil {
+			if err != io.EOF {
+				_ = m.closeChannel(ch.id, err)
+			} else {
+				_ = m.closeChannel(ch.id, nil)
+			}
+			return
+		}
+	}
+}
+
+func (m *channelMux) dataFrame(channelID int, data []byte) ([]byte, error) {
+	payload, err := json.Marshal(argChannelData{
+		ChannelID: channelID,
+		Data:      base64.StdEncoding.EncodeToString(data),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{ChannelData}, payload...), nil
+}
+
+// HandleChannelData forwards a ChannelData frame's payload from the master
+// to the corresponding local connection.
+func (m *channelMux) HandleChannelData(data []byte) error {
+	var args argChannelData
+	if err := json.Unmarshal(data, &args); err != nil {
+		return errors.Wrapf(err, "received malformed channel-data message")
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(args.Data)
+	if err != nil {
+		return errors.Wrapf(err, "received malformed channel-data payload")
+	}
+
+	m.mu.Lock()
+	ch, ok := m.channels[args.ChannelID]
+	m.mu.Unlock()
+	if !ok {
+		return nil // channel already closed; drop stray data
+	}
+
+	if _, err := ch.conn.Write(decoded); err != nil {
+		return m.closeChannel(args.ChannelID, err)
+	}
+
+	return nil
+}
+
+// HandleCloseChannel closes the local connection for a channel the browser
+// is tearing down.
+func (m *channelMux) HandleCloseChannel(data []byte) error {
+	var args argCloseChannel
+	if err := json.Unmarshal(data, &args); err != nil {
+		return errors.Wrapf(err, "received malformed close-channel message")
+	}
+
+	return m.closeChannel(args.ChannelID, nil)
+}
+
+// closeChannel closes and forgets channelID, notifying the master with a
+// CloseChannel frame. cause, if non-nil, is only used to decide whether
+// there's anything worth returning to the caller; it is not sent to the
+// client.
+func (m *channelMux) closeChannel(channelID int, cause error) error {
+	m.removeChannel(channelID)
+
+	payload, err := json.Marshal(argCloseChannel{ChannelID: channelID})
+	if err != nil {
+		return err
+	}
+	if writeErr := m.wt.masterWrite(append([]byte{CloseChannel}, payload...)); writeErr != nil {
+		return writeErr
+	}
+
+	return cause
+}
+
+func (m *channelMux) removeChannel(channelID int) {
+	m.mu.Lock()
+	ch, ok := m.channels[channelID]
+	m.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	ch.conn.Close()
+
+	m.mu.Lock()
+	delete(m.channels, channelID)
+	m.mu.Unlock()
+}
@@ -0,0 +1,134 @@
+package webtty
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+)
+
+func newTestChannelMux(t *testing.T, allowed ...string) (*channelMux, *fakeMaster) {
+	t.Helper()
+	master := &fakeMaster{}
+	wt := &WebTTY{masterConn: master, bufferSize: 1024}
+	return newChannelMux(wt, allowed), master
+}
+
+func TestHandleOpenChannelRejectsNonWhitelistedTarget(t *testing.T) {
+	m, master := newTestChannelMux(t, "127.0.0.1:9000")
+
+	data, _ := json.Marshal(argOpenChannel{ChannelID: 1, Target: "evil.example:22"})
+	if err := m.HandleOpenChannel(data); err == nil {
+		t.Error("HandleOpenChannel returned no error for a target outside the whitelist")
+	}
+
+	if len(master.written) != 1 {
+		t.Fatalf("got %d frames written, want 1 (a CloseChannel)", len(master.written))
+	}
+	if master.written[0][0] != CloseChannel {
+		t.Errorf("frame type = %q, want CloseChannel", master.written[0][0])
+	}
+
+	m.mu.Lock()
+	_, stillOpen := m.channels[1]
+	m.mu.Unlock()
+	if stillOpen {
+		t.Error("a rejected target left a channel registered")
+	}
+}
+
+func TestHandleChannelDataForwardsDecodedBytesToLocalConn(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer ln.Close()
+
+	received := make(chan []byte, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, 64)
+		n, _ := conn.Read(buf)
+		received <- buf[:n]
+	}()
+
+	m, _ := newTestChannelMux(t, ln.Addr().String())
+
+	openData, _ := json.Marshal(argOpenChannel{ChannelID: 7, Target: ln.Addr().String()})
+	if err := m.HandleOpenChannel(openData); err != nil {
+		t.Fatalf("HandleOpenChannel: %v", err)
+	}
+
+	payload := []byte("hello forwarded target")
+	dataMsg, _ := json.Marshal(argChannelData{
+		ChannelID: 7,
+		Data:      base64.StdEncoding.EncodeToString(payload),
+	})
+	if err := m.HandleChannelData(dataMsg); err != nil {
+		t.Fatalf("HandleChannelData: %v", err)
+	}
+
+	select {
+	case got := <-received:
+		if string(got) != string(payload) {
+			t.Errorf("local target received %q, want %q", got, payload)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("local target never received the forwarded data")
+	}
+
+	m.removeChannel(7)
+}
+
+func TestHandleChannelDataDropsStrayDataForUnknownChannel(t *testing.T) {
+	m, _ := newTestChannelMux(t)
+
+	dataMsg, _ := json.Marshal(argChannelData{
+		ChannelID: 99,
+		Data:      base64.StdEncoding.EncodeToString([]byte("stray")),
+	})
+	if err := m.HandleChannelData(dataMsg); err != nil {
+		t.Fatalf("HandleChannelData for an unknown channel returned an error: %v", err)
+	}
+}
+
+func TestHandleCloseChannelRemovesChannelAndNotifiesMaster(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			conn.Close()
+		}
+	}()
+
+	m, master := newTestChannelMux(t, ln.Addr().String())
+	openData, _ := json.Marshal(argOpenChannel{ChannelID: 3, Target: ln.Addr().String()})
+	if err := m.HandleOpenChannel(openData); err != nil {
+		t.Fatalf("HandleOpenChannel: %v", err)
+	}
+
+	closeData, _ := json.Marshal(argCloseChannel{ChannelID: 3})
+	if err := m.HandleCloseChannel(closeData); err != nil {
+		t.Fatalf("HandleCloseChannel: %v", err)
+	}
+
+	m.mu.Lock()
+	_, stillOpen := m.channels[3]
+	m.mu.Unlock()
+	if stillOpen {
+		t.Error("HandleCloseChannel did not remove the channel")
+	}
+
+	if len(master.written) != 1 || master.written[0][0] != CloseChannel {
+		t.Errorf("master frames = %v, want exactly one CloseChannel frame", master.written)
+	}
+}
@@ -0,0 +1,349 @@
+package webtty
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"sync"
+
+	"github.com/pborman/ansi"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/sorenisanerd/gotty/audit"
+	"github.com/sorenisanerd/gotty/utils"
+)
+
+// Role describes the permissions a Master attached to a SessionHub has over
+// the shared PTY, negotiated when the client joins via its join token.
+type Role int
+
+const (
+	// RoleReadOnly viewers receive Output frames but their Input is
+	// dropped.
+	RoleReadOnly Role = iota
+	// RoleWriter viewers' Input is forwarded to the slave.
+	RoleWriter
+	// RoleOwner behaves like RoleWriter; SessionHub doesn't otherwise
+	// distinguish it, but callers can use it to grant session-management
+	// privileges of their own (e.g. kicking other viewers).
+	RoleOwner
+)
+
+// MasterJoin and MasterLeave are WebTTY message types emitted by a
+// SessionHub to every other attached Master whenever a viewer joins or leaves.
+// The payload is the joining/leaving viewer's username. They're chosen
+// outside the digit range the existing client/server message types use to
+// avoid colliding with them.
+const (
+	MasterJoin  = 'J'
+	MasterLeave = 'L'
+)
+
+// viewer is one Master attached to a SessionHub.
+type viewer struct {
+	id       int
+	conn     Master
+	role     Role
+	username string
+	decoder  Decoder
+
+	writeMu sync.Mutex
+
+	// columns/rows are this viewer's last-reported geometry; 0 means
+	// "hasn't reported one yet" and it's excluded from the smallest
+	// common geometry computation.
+	columns, rows int
+}
+
+func (v *viewer) write(data []byte) error {
+	v.writeMu.Lock()
+	defer v.writeMu.Unlock()
+
+	_, err := v.conn.Write(data)
+	return err
+}
+
+// SessionHub fans a single PTY Slave's output out to any number of attached
+// Master connections, and multiplexes Input from whichever of them hold
+// RoleWriter or RoleOwner. It exists to support session sharing (similar
+// to tmate/tty-share), where WebTTY.Run's strict 1:1 master/slave pairing
+// isn't enough. A WebTTY can own a SessionHub (see WithSessionHub) so its
+// own connection is just another attached viewer; additional viewers
+// attach directly via Attach.
+type SessionHub struct {
+	slave Slave
+
+	mu      sync.Mutex
+	viewers map[int]*viewer
+	nextID  int
+	columns int // smallest common geometry among attached viewers
+	rows    int
+
+	auditSink audit.Sink
+	recorder  *audit.Recorder
+	sessionID string
+	logger    *zerolog.Logger
+}
+
+// HubOption is used to configure optional SessionHub properties.
+type HubOption func(*SessionHub)
+
+// WithHubAuditSink enables per-viewer audit logging, keyed by username,
+// through sink.
+func WithHubAuditSink(sink audit.Sink) HubOption {
+	return func(hub *SessionHub) {
+		hub.auditSink = sink
+	}
+}
+
+// WithHubSessionID sets the identifier audit events and recordings are
+// keyed by.
+func WithHubSessionID(id string) HubOption {
+	return func(hub *SessionHub) {
+		hub.sessionID = id
+	}
+}
+
+// WithHubRecorder records every viewer's I/O into the same asciicast v2
+// recording as the session's primary connection.
+func WithHubRecorder(rec *audit.Recorder) HubOption {
+	return func(hub *SessionHub) {
+		hub.recorder = rec
+	}
+}
+
+// NewSessionHub creates a SessionHub owning slave.
+func NewSessionHub(slave Slave, options ...HubOption) *SessionHub {
+	hub := &SessionHub{
+		slave:   slave,
+		viewers: make(map[int]*viewer),
+		logger:  &log.Logger,
+	}
+
+	for _, option := range options {
+		option(hub)
+	}
+
+	return hub
+}
+
+// Attach joins conn to the shared session with the given role and username,
+// and returns the id HandleMasterFrame and the detach func use to refer to
+// it. The caller must invoke detach when conn closes (e.g. on a read
+// error). Every other attached viewer is notified via a MasterJoin message.
+func (hub *SessionHub) Attach(conn Master, role Role, username string) (id int, detach func(), err error) {
+	hub.mu.Lock()
+	id = hub.nextID
+	hub.nextID++
+	hub.viewers[id] = &viewer{id: id, conn: conn, role: role, username: username, decoder: NullCodec{}}
+	hub.mu.Unlock()
+
+	hub.broadcastExcept(id, append([]byte{MasterJoin}, []byte(username)...))
+	hub.auditJoinLeave(username, true)
+
+	detach = func() {
+		hub.removeViewer(id)
+		hub.broadcastExcept(id, append([]byte{MasterLeave}, []byte(username)...))
+		hub.auditJoinLeave(username, false)
+	}
+
+	return id, detach, nil
+}
+
+// HandleMasterFrame dispatches a raw frame (including its leading message
+// type byte) read from the viewer identified by id, the same way
+// WebTTY.handleMasterReadEvent does for a 1:1 session.
+func (hub *SessionHub) HandleMasterFrame(id int, data []byte) error {
+	if len(data) == 0 {
+		return errors.New("unexpected zero length read from master")
+	}
+
+	hub.mu.Lock()
+	v, ok := hub.viewers[id]
+	hub.mu.Unlock()
+	if !ok {
+		return errors.Errorf("unknown viewer %d", id)
+	}
+
+	switch data[0] {
+	case Input:
+		if v.role == RoleReadOnly || len(data) <= 1 {
+			return nil
+		}
+
+		decoded := make([]byte, len(data))
+		n, err := v.decoder.Decode(decoded, data[1:])
+		if err != nil {
+			return errors.Wrapf(err, "failed to decode received data")
+		}
+
+		hub.logBrowser(v.username, decoded[:n])
+		if hub.recorder != nil {
+			if err := hub.recorder.Input(decoded[:n]); err != nil {
+				return errors.Wrapf(err, "failed to record master input")
+			}
+		}
+		if _, err := hub.slave.Write(decoded[:n]); err != nil {
+			return errors.Wrapf(err, "failed to write received data to slave")
+		}
+
+	case Ping:
+		return v.write([]byte{Pong})
+
+	case SetEncoding:
+		switch string(data[1:]) {
+		case "base64":
+			v.decoder = base64.StdEncoding
+		case "null":
+			v.decoder = NullCodec{}
+		}
+
+	case ResizeTerminal:
+		if len(data) <= 1 {
+			return errors.New("received malformed remote command for terminal resize: empty payload")
+		}
+
+		var args argResizeTerminal
+		if err := json.Unmarshal(data[1:], &args); err != nil {
+			return errors.Wrapf(err, "received malformed data for terminal resize")
+		}
+
+		hub.setViewerGeometry(id, int(args.Columns), int(args.Rows))
+
+	default:
+		return errors.Errorf("unknown message type `%c`", data[0])
+	}
+
+	return nil
+}
+
+// setViewerGeometry records id's requested geometry and resizes the shared
+// slave to the resulting smallest common geometry across all attached
+// viewers, whether or not that geometry changed.
+func (hub *SessionHub) setViewerGeometry(id, columns, rows int) {
+	hub.mu.Lock()
+	if v, ok := hub.viewers[id]; ok {
+		v.columns, v.rows = columns, rows
+	}
+	columns, rows = hub.recomputeGeometryLocked()
+	hub.mu.Unlock()
+
+	if columns > 0 && rows > 0 {
+		hub.slave.ResizeTerminal(columns, rows)
+		if hub.recorder != nil {
+			_ = hub.recorder.Resize(columns, rows)
+		}
+	}
+}
+
+// recomputeGeometryLocked returns the smallest columns/rows among viewers
+// that have reported a geometry, or 0/0 if none have. Callers must hold
+// hub.mu; it also updates hub.columns/hub.rows as a side effect.
+func (hub *SessionHub) recomputeGeometryLocked() (columns, rows int) {
+	for _, v := range hub.viewers {
+		if v.columns == 0 || v.rows == 0 {
+			continue
+		}
+		if columns == 0 || v.columns < columns {
+			columns = v.columns
+		}
+		if rows == 0 || v.rows < rows {
+			rows = v.rows
+		}
+	}
+	hub.columns, hub.rows = columns, rows
+	return columns, rows
+}
+
+// broadcastOutput sends data to every attached viewer as an Output frame.
+// It always base64-encodes, matching WebTTY.handleSlaveReadEvent's default
+// text transport: viewers negotiate independently and a shared session
+// can't assume they all support the binary transport, so broadcasting raw
+// bytes would corrupt output for any viewer that doesn't.
+func (hub *SessionHub) broadcastOutput(data []byte) {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	hub.broadcastExcept(-1, append([]byte{Output}, []byte(encoded)...))
+
+	if hub.recorder != nil {
+		_ = hub.recorder.Output(data)
+	}
+	hub.logBackend(data)
+}
+
+func (hub *SessionHub) broadcastExcept(exceptID int, frame []byte) {
+	hub.mu.Lock()
+	conns := make([]*viewer, 0, len(hub.viewers))
+	for id, v := range hub.viewers {
+		if id == exceptID {
+			continue
+		}
+		conns = append(conns, v)
+	}
+	hub.mu.Unlock()
+
+	for _, v := range conns {
+		if err := v.write(frame); err != nil {
+			hub.logger.Info().Str("session-id", hub.sessionID).Str("username", v.username).Err(err).Msg("failed to write to viewer, detaching")
+			hub.removeViewer(v.id)
+		}
+	}
+}
+
+// removeViewer drops id from hub.viewers and resizes the shared slave to
+// the resulting smallest common geometry, the same cleanup Attach's
+// detach func does for a viewer that closes normally. It's also used for
+// a viewer whose connection turns out to be dead when broadcastExcept
+// tries to write to it.
+func (hub *SessionHub) removeViewer(id int) {
+	hub.mu.Lock()
+	delete(hub.viewers, id)
+	columns, rows := hub.recomputeGeometryLocked()
+	hub.mu.Unlock()
+
+	if columns > 0 && rows > 0 {
+		hub.slave.ResizeTerminal(columns, rows)
+	}
+}
+
+func (hub *SessionHub) logBackend(data []byte) {
+	if hub.auditSink == nil {
+		return
+	}
+	stripped, _ := ansi.Strip(data)
+	hub.auditSink.Log(audit.Event{
+		SessionID: hub.sessionID,
+		Stream:    "backend",
+		Message:   utils.RemoveNonGraphicChar(string(stripped)),
+	})
+}
+
+func (hub *SessionHub) logBrowser(username string, data []byte) {
+	if hub.auditSink == nil {
+		return
+	}
+	stripped, _ := ansi.Strip(data)
+	hub.auditSink.Log(audit.Event{
+		SessionID: hub.sessionID,
+		Username:  username,
+		Stream:    "browser",
+		Message:   utils.RemoveNonGraphicChar(string(stripped)),
+	})
+}
+
+func (hub *SessionHub) auditJoinLeave(username string, joined bool) {
+	if hub.auditSink == nil {
+		return
+	}
+
+	action := "joined"
+	if !joined {
+		action = "left"
+	}
+
+	hub.auditSink.Log(audit.Event{
+		SessionID: hub.sessionID,
+		Username:  username,
+		Stream:    "session",
+		Message:   action,
+	})
+}
@@ -0,0 +1,132 @@
+package webtty
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"testing"
+)
+
+// fakeSlave is a minimal Slave for hub/webtty tests: Read returns io.EOF at
+// once (tests don't exercise the slave-read loop), Write discards its input,
+// and ResizeTerminal records what it was called with.
+type fakeSlave struct {
+	mu      sync.Mutex
+	resizes [][2]int
+}
+
+func (s *fakeSlave) Read(p []byte) (int, error) { return 0, io.EOF }
+func (s *fakeSlave) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+func (s *fakeSlave) Close() error { return nil }
+func (s *fakeSlave) WindowTitleVariables() map[string]interface{} {
+	return nil
+}
+func (s *fakeSlave) ResizeTerminal(columns, rows int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.resizes = append(s.resizes, [2]int{columns, rows})
+	return nil
+}
+
+func (s *fakeSlave) lastResize() (int, int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.resizes) == 0 {
+		return 0, 0, false
+	}
+	last := s.resizes[len(s.resizes)-1]
+	return last[0], last[1], true
+}
+
+// fakeMaster is a minimal Master for hub tests. When failWrites is set,
+// every Write fails, simulating a closed connection.
+type fakeMaster struct {
+	mu         sync.Mutex
+	written    [][]byte
+	failWrites bool
+}
+
+func (m *fakeMaster) Read(p []byte) (int, error) { return 0, io.EOF }
+func (m *fakeMaster) Write(p []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.failWrites {
+		return 0, errors.New("connection closed")
+	}
+	cp := append([]byte(nil), p...)
+	m.written = append(m.written, cp)
+	return len(p), nil
+}
+
+var _ io.ReadWriter = (*fakeMaster)(nil)
+
+func TestSessionHubGeometryIsSmallestCommonAcrossViewers(t *testing.T) {
+	slave := &fakeSlave{}
+	hub := NewSessionHub(slave)
+
+	id1, _, err := hub.Attach(&fakeMaster{}, RoleOwner, "owner")
+	if err != nil {
+		t.Fatalf("Attach: %v", err)
+	}
+	id2, _, err := hub.Attach(&fakeMaster{}, RoleReadOnly, "viewer")
+	if err != nil {
+		t.Fatalf("Attach: %v", err)
+	}
+
+	hub.setViewerGeometry(id1, 120, 40)
+	if cols, rows, ok := slave.lastResize(); !ok || cols != 120 || rows != 40 {
+		t.Fatalf("after first viewer's geometry: got (%d,%d,%v), want (120,40,true)", cols, rows, ok)
+	}
+
+	hub.setViewerGeometry(id2, 80, 24)
+	cols, rows, ok := slave.lastResize()
+	if !ok || cols != 80 || rows != 24 {
+		t.Fatalf("after second (smaller) viewer's geometry: got (%d,%d,%v), want (80,24,true)", cols, rows, ok)
+	}
+}
+
+func TestSessionHubDetachRecomputesGeometry(t *testing.T) {
+	slave := &fakeSlave{}
+	hub := NewSessionHub(slave)
+
+	id1, detach1, _ := hub.Attach(&fakeMaster{}, RoleOwner, "owner")
+	id2, _, _ := hub.Attach(&fakeMaster{}, RoleReadOnly, "viewer")
+
+	hub.setViewerGeometry(id1, 120, 40)
+	hub.setViewerGeometry(id2, 80, 24)
+
+	detach1()
+
+	if _, ok := hub.viewers[id1]; ok {
+		t.Fatal("detach did not remove the viewer from hub.viewers")
+	}
+
+	cols, rows, ok := slave.lastResize()
+	if !ok || cols != 80 || rows != 24 {
+		t.Fatalf("after detaching the larger viewer: got (%d,%d,%v), want the remaining viewer's own (80,24,true)", cols, rows, ok)
+	}
+}
+
+func TestSessionHubBroadcastExceptDetachesDeadViewer(t *testing.T) {
+	slave := &fakeSlave{}
+	hub := NewSessionHub(slave)
+
+	dead := &fakeMaster{failWrites: true}
+	deadID, _, _ := hub.Attach(dead, RoleReadOnly, "dead")
+	alive := &fakeMaster{}
+	aliveID, _, _ := hub.Attach(alive, RoleReadOnly, "alive")
+
+	hub.broadcastExcept(-1, []byte("hello"))
+
+	if _, ok := hub.viewers[deadID]; ok {
+		t.Error("broadcastExcept left a viewer whose write failed in hub.viewers")
+	}
+	if _, ok := hub.viewers[aliveID]; !ok {
+		t.Error("broadcastExcept removed a viewer whose write succeeded")
+	}
+	if len(alive.written) != 1 {
+		t.Errorf("alive viewer received %d frames, want 1", len(alive.written))
+	}
+}
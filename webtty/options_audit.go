@@ -0,0 +1,28 @@
+package webtty
+
+import "github.com/sorenisanerd/gotty/audit"
+
+// WithSessionID sets the identifier audit events and recordings for this
+// session are keyed by.
+func WithSessionID(id string) Option {
+	return func(wt *WebTTY) {
+		wt.sessionID = id
+	}
+}
+
+// WithAuditSink enables structured audit logging of session I/O through
+// sink, replacing the previous behavior of logging stripped-ANSI text
+// directly through the WebTTY's zerolog.Logger.
+func WithAuditSink(sink audit.Sink) Option {
+	return func(wt *WebTTY) {
+		wt.auditSink = sink
+	}
+}
+
+// WithRecorder enables full-fidelity asciicast v2 recording of the
+// session's terminal I/O through rec.
+func WithRecorder(rec *audit.Recorder) Option {
+	return func(wt *WebTTY) {
+		wt.recorder = rec
+	}
+}
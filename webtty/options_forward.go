@@ -0,0 +1,10 @@
+package webtty
+
+// WithForwardTargets enables local port-forwarding channels over this
+// session's websocket, restricted to the given "host:port" targets (as
+// configured by the server's --forward flag).
+func WithForwardTargets(targets []string) Option {
+	return func(wt *WebTTY) {
+		wt.channels = newChannelMux(wt, targets)
+	}
+}
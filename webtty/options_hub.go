@@ -0,0 +1,10 @@
+package webtty
+
+// WithSessionHub attaches this WebTTY's own master connection to hub as a
+// viewer when Run starts, so additional viewers can share the session
+// through the same hub (see SessionHub).
+func WithSessionHub(hub *SessionHub) Option {
+	return func(wt *WebTTY) {
+		wt.hub = hub
+	}
+}
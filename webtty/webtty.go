@@ -6,14 +6,24 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"sync"
+	"sync/atomic"
 
 	"github.com/pborman/ansi"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"github.com/sorenisanerd/gotty/audit"
 	"github.com/sorenisanerd/gotty/utils"
 )
 
+// BinaryWriter is implemented by a Master connection that can send a
+// distinct binary websocket frame instead of a text one, e.g. for the
+// transport negotiated by SetCapabilities. Master implementations that
+// don't implement it only ever see base64-encoded Output frames.
+type BinaryWriter interface {
+	WriteBinary(data []byte) error
+}
+
 // WebTTY bridges a PTY slave and its PTY master.
 // To support text-based streams and side channel commands such as
 // terminal resizing, WebTTY uses an original protocol.
@@ -23,15 +33,21 @@ type WebTTY struct {
 	// PTY Slave
 	slave Slave
 
-	windowTitle  []byte
-	permitWrite  bool
-	columns      int
-	rows         int
-	reconnect    int // in seconds
-	masterPrefs  []byte
-	username     string
-	auditEnabled bool
-	decoder      Decoder
+	windowTitle []byte
+	permitWrite bool
+	columns     int
+	rows        int
+	reconnect   int // in seconds
+	masterPrefs []byte
+	username    string
+	sessionID   string
+	auditSink   audit.Sink
+	recorder    *audit.Recorder
+	decoder     Decoder
+	binaryMode  int32 // accessed via sync/atomic; set from the master-read goroutine, read from the slave-read goroutine
+	channels    *channelMux
+	hub         *SessionHub
+	hubViewerID int // id this connection was given by hub.Attach, valid only when hub != nil
 
 	bufferSize int
 	writeMutex sync.Mutex
@@ -77,18 +93,41 @@ func (wt *WebTTY) Run(ctx context.Context) error {
 		return errors.Wrapf(err, "failed to send initializing message")
 	}
 
+	if wt.hub != nil {
+		// This WebTTY's own connection is just another viewer of the
+		// shared session; additional viewers attach directly via
+		// SessionHub.Attach.
+		role := RoleReadOnly
+		if wt.permitWrite {
+			// The primary connection is the session's creator, so it
+			// gets RoleOwner rather than plain RoleWriter.
+			role = RoleOwner
+		}
+		id, detach, err := wt.hub.Attach(wt.masterConn, role, wt.username)
+		if err != nil {
+			return errors.Wrapf(err, "failed to attach to session hub")
+		}
+		wt.hubViewerID = id
+		defer detach()
+	}
+
 	errs := make(chan error, 2)
 
 	go func() {
 		errs <- func() error {
 			buffer := make([]byte, wt.bufferSize)
 			for {
-				//base64 length
-				effectiveBufferSize := wt.bufferSize - 1
-				//max raw data length
-				maxChunkSize := int(effectiveBufferSize/4) * 3
+				// In base64 mode every 3 raw bytes cost 4 encoded
+				// bytes, so the slave read has to be capped well
+				// below bufferSize. Binary mode sends raw bytes
+				// and can read the full buffer.
+				readSize := wt.bufferSize
+				if atomic.LoadInt32(&wt.binaryMode) == 0 {
+					effectiveBufferSize := wt.bufferSize - 1
+					readSize = int(effectiveBufferSize/4) * 3
+				}
 
-				n, err := wt.slave.Read(buffer[:maxChunkSize])
+				n, err := wt.slave.Read(buffer[:readSize])
 				if err != nil {
 					return ErrSlaveClosed
 				}
@@ -103,7 +142,14 @@ func (wt *WebTTY) Run(ctx context.Context) error {
 
 	go func() {
 		errs <- func() error {
-			buffer := make([]byte, wt.bufferSize)
+			// Unlike the slave-read loop, this buffer isn't the
+			// negotiated protocol bufferSize: each Read is expected to
+			// return exactly one complete frame, and a ChannelData
+			// frame's JSON+base64 envelope can run well past bufferSize
+			// for a single forwarded-connection read. masterReadBufferSize
+			// gives that room; wsWrapper.Read errors instead of silently
+			// splitting a frame across reads if a message ever exceeds it.
+			buffer := make([]byte, masterReadBufferSize)
 			for {
 				n, err := wt.masterConn.Read(buffer)
 				if err != nil {
@@ -158,8 +204,38 @@ func (wt *WebTTY) sendInitializeMessage() error {
 }
 
 func (wt *WebTTY) handleSlaveReadEvent(data []byte) error {
-	safeMessage := base64.StdEncoding.EncodeToString(data)
-	err := wt.masterWrite(append([]byte{Output}, []byte(safeMessage)...))
+	if wt.hub != nil {
+		// The hub fans this out to every attached viewer itself,
+		// base64-encoding consistently for all of them since they
+		// negotiate transport independently, and it owns recording
+		// (via WithHubRecorder) so the shared session's output isn't
+		// recorded twice.
+		wt.hub.broadcastOutput(data)
+		return nil
+	}
+
+	if wt.recorder != nil {
+		if err := wt.recorder.Output(data); err != nil {
+			return errors.Wrapf(err, "failed to record slave output")
+		}
+	}
+
+	var err error
+	if atomic.LoadInt32(&wt.binaryMode) != 0 {
+		if bw, ok := wt.masterConn.(BinaryWriter); ok {
+			err = bw.WriteBinary(append([]byte{Output}, data...))
+		} else {
+			// masterConn can't actually send a binary frame, so
+			// sending raw bytes as "text" would desync the legacy
+			// protocol or fail a browser's UTF-8 validation; fall
+			// back to the safe base64 encoding.
+			safeMessage := base64.StdEncoding.EncodeToString(data)
+			err = wt.masterWrite(append([]byte{Output}, []byte(safeMessage)...))
+		}
+	} else {
+		safeMessage := base64.StdEncoding.EncodeToString(data)
+		err = wt.masterWrite(append([]byte{Output}, []byte(safeMessage)...))
+	}
 	if err != nil {
 		return errors.Wrapf(err, "failed to send message to master")
 	}
@@ -201,11 +277,51 @@ func (wt *WebTTY) handleMasterReadEvent(data []byte) error {
 		}
 
 		wt.auditLogs(decodedBuffer[:n], true)
+		if wt.recorder != nil {
+			if err := wt.recorder.Input(decodedBuffer[:n]); err != nil {
+				return errors.Wrapf(err, "failed to record master input")
+			}
+		}
 		_, err = wt.slave.Write(decodedBuffer[:n])
 		if err != nil {
 			return errors.Wrapf(err, "failed to write received data to slave")
 		}
 
+	case OpenChannel:
+		// Port-forwarding dials a whitelisted local target on the
+		// viewer's behalf, which is at least as sensitive as writing
+		// to the shell; gate it the same as Input.
+		if !wt.permitWrite || wt.channels == nil || len(data) <= 1 {
+			return nil
+		}
+		return wt.channels.HandleOpenChannel(data[1:])
+
+	case ChannelData:
+		if !wt.permitWrite || wt.channels == nil || len(data) <= 1 {
+			return nil
+		}
+		return wt.channels.HandleChannelData(data[1:])
+
+	case CloseChannel:
+		if !wt.permitWrite || wt.channels == nil || len(data) <= 1 {
+			return nil
+		}
+		return wt.channels.HandleCloseChannel(data[1:])
+
+	case SetCapabilities:
+		var caps argCapabilities
+		err := json.Unmarshal(data[1:], &caps)
+		if err != nil {
+			return errors.Wrapf(err, "received malformed capabilities message")
+		}
+		if caps.Binary {
+			// Binary frames carry raw bytes end to end, so there's
+			// nothing left for the base64/null codec to do on the
+			// way in either.
+			atomic.StoreInt32(&wt.binaryMode, 1)
+			wt.decoder = NullCodec{}
+		}
+
 	case Ping:
 		err := wt.masterWrite([]byte{Pong})
 		if err != nil {
@@ -244,7 +360,21 @@ func (wt *WebTTY) handleMasterReadEvent(data []byte) error {
 			columns = int(args.Columns)
 		}
 
+		if wt.hub != nil {
+			// Register this connection's geometry with the hub instead
+			// of resizing the slave directly, so the shared session
+			// converges on the smallest common geometry across every
+			// viewer, this one included, instead of fighting over it.
+			wt.hub.setViewerGeometry(wt.hubViewerID, columns, rows)
+			break
+		}
+
 		wt.slave.ResizeTerminal(columns, rows)
+		if wt.recorder != nil {
+			if err := wt.recorder.Resize(columns, rows); err != nil {
+				return errors.Wrapf(err, "failed to record terminal resize")
+			}
+		}
 	default:
 		return errors.Errorf("unknown message type `%c`", data[0])
 	}
@@ -252,13 +382,34 @@ func (wt *WebTTY) handleMasterReadEvent(data []byte) error {
 	return nil
 }
 
+// masterReadBufferSize is the size of the buffer the master-read loop
+// hands to Master.Read. It's sized for the largest frame a legitimate
+// client sends in one websocket message -- a ChannelData frame carrying
+// a full forwarded-connection read, base64-encoded and JSON-wrapped --
+// rather than the much smaller negotiated protocol bufferSize.
+const masterReadBufferSize = 64 * 1024
+
 type argResizeTerminal struct {
 	Columns float64
 	Rows    float64
 }
 
+// SetCapabilities is sent by the master right after connecting to
+// negotiate transport features beyond the legacy base64 text protocol.
+// It's chosen outside the digit range the existing message types use to
+// avoid colliding with them.
+const SetCapabilities = 'c'
+
+// argCapabilities is the JSON payload of a SetCapabilities message.
+type argCapabilities struct {
+	// Binary requests that Output/Input frames carry raw bytes instead
+	// of base64 text. Ignored by clients/servers that only understand
+	// the legacy protocol, which fall back to the base64 default.
+	Binary bool `json:"binary"`
+}
+
 func (wt *WebTTY) auditLogs(buffer []byte, fromFe bool) {
-	if !wt.auditEnabled {
+	if wt.auditSink == nil {
 		return
 	}
 	buffer, _ = ansi.Strip(buffer)
@@ -283,9 +434,10 @@ func (wt *WebTTY) printAuditLogs(logs []byte, fe bool) {
 		stream = "backend"
 	}
 
-	logger := wt.logger.Info().Str("log-type", "audit").Str("stream", stream)
-	if wt.username != "" {
-		logger.Str("username", wt.username)
-	}
-	logger.Msg(utils.RemoveNonGraphicChar(string(logs)))
+	wt.auditSink.Log(audit.Event{
+		SessionID: wt.sessionID,
+		Username:  wt.username,
+		Stream:    stream,
+		Message:   utils.RemoveNonGraphicChar(string(logs)),
+	})
 }